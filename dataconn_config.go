@@ -0,0 +1,46 @@
+package server
+
+import "time"
+
+// DataConnConfig controls the timeouts and keepalive behaviour used when
+// establishing and operating active/passive data connections. A nil
+// *DataConnConfig is treated the same as DefaultDataConnConfig() by the
+// socket constructors, so existing callers that don't know about this type
+// keep the historical 15 second timeouts.
+type DataConnConfig struct {
+	// DialTimeout bounds how long an active (PORT) data connection is
+	// allowed to take to connect to the client.
+	DialTimeout time.Duration
+
+	// PassiveAcceptTimeout bounds how long a passive (PASV/EPSV) listener
+	// will wait for the client to connect before giving up.
+	PassiveAcceptTimeout time.Duration
+
+	// IdleTimeout, when non-zero, is applied as a rolling deadline before
+	// every Read and Write on the data connection so a stalled transfer
+	// doesn't hang forever. It stops being applied once the caller sets its
+	// own deadline via SetDeadline/SetReadDeadline/SetWriteDeadline.
+	IdleTimeout time.Duration
+
+	// Keepalive is passed through to net.Dialer.KeepAlive for active
+	// connections. Zero (the default) enables keepalive probes at Go's
+	// default interval (currently 15 seconds), matching what net.DialTimeout did before this
+	// type existed; negative disables keepalive probes entirely.
+	Keepalive time.Duration
+}
+
+// DefaultDataConnConfig returns the timeouts the package has always used.
+func DefaultDataConnConfig() *DataConnConfig {
+	return &DataConnConfig{
+		DialTimeout:          15 * time.Second,
+		PassiveAcceptTimeout: 15 * time.Second,
+	}
+}
+
+func dataConnConfigOrDefault(config *DataConnConfig) *DataConnConfig {
+	if config == nil {
+		return DefaultDataConnConfig()
+	}
+
+	return config
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// DataConnLimiter gates how many DataSockets can be open at once, the way
+// rclone's `--transfers` flag bounds concurrent transfers. A nil
+// *DataConnLimiter imposes no limit, so socket constructors given a nil
+// limiter keep today's unbounded behaviour.
+type DataConnLimiter struct {
+	sem   *semaphore.Weighted
+	pacer Pacer
+
+	active   int64
+	pending  int64
+	rejected int64
+}
+
+// NewDataConnLimiter builds a limiter that allows at most concurrency data
+// connections at a time (0 or negative means unlimited, returning a nil
+// *DataConnLimiter). pacer, if non-nil, is consulted before an admission
+// attempt that finds the pool full, so a hammering client gets slowed down
+// rather than just queued; uncontended admissions are never paced.
+func NewDataConnLimiter(concurrency int, pacer Pacer) *DataConnLimiter {
+	if concurrency <= 0 {
+		return nil
+	}
+
+	return &DataConnLimiter{
+		sem:   semaphore.NewWeighted(int64(concurrency)),
+		pacer: pacer,
+	}
+}
+
+// DataConnCounters is a point-in-time snapshot of limiter state, named to
+// match the Prometheus gauges (active_data_conns, pending, rejected) an
+// operator would scrape to size the pool.
+type DataConnCounters struct {
+	Active   int64
+	Pending  int64
+	Rejected int64
+}
+
+// Counters returns the current gauge values.
+func (l *DataConnLimiter) Counters() DataConnCounters {
+	if l == nil {
+		return DataConnCounters{}
+	}
+
+	return DataConnCounters{
+		Active:   atomic.LoadInt64(&l.active),
+		Pending:  atomic.LoadInt64(&l.pending),
+		Rejected: atomic.LoadInt64(&l.rejected),
+	}
+}
+
+// acquire blocks until a slot is free, or returns an error immediately if
+// ctx is done first. Every successful acquire must be matched with a
+// release. The pacer is only consulted - and only ever backs off further -
+// when the pool is actually contended; an uncontended acquire resets it, so
+// a pacer that ratcheted up under a burst of load recovers once that load
+// subsides instead of pacing every connection at Max delay forever.
+func (l *DataConnLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	if l.sem.TryAcquire(1) {
+		atomic.AddInt64(&l.active, 1)
+
+		if resettable, ok := l.pacer.(interface{ reset() }); ok {
+			resettable.reset()
+		}
+
+		return nil
+	}
+
+	atomic.AddInt64(&l.pending, 1)
+	defer atomic.AddInt64(&l.pending, -1)
+
+	if l.pacer != nil {
+		l.pacer.Pace()
+	}
+
+	if err := l.sem.Acquire(ctx, 1); err != nil {
+		atomic.AddInt64(&l.rejected, 1)
+		return err
+	}
+
+	atomic.AddInt64(&l.active, 1)
+
+	return nil
+}
+
+// acquireContext bounds how long acquire will wait for a free slot by the
+// same timeout that already governs the dial/accept this connection is
+// for, so a saturated pool rejects (and counts against Rejected) instead of
+// blocking forever. A non-positive timeout means "wait indefinitely", the
+// historical behaviour before DataConnLimiter existed.
+func acquireContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.Background(), func() {}
+	}
+
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+func (l *DataConnLimiter) release() {
+	if l == nil {
+		return
+	}
+
+	atomic.AddInt64(&l.active, -1)
+	l.sem.Release(1)
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDataConnLimiterRejectsOnSaturationTimeout(t *testing.T) {
+	limiter := NewDataConnLimiter(1, nil)
+
+	ctx, cancel := acquireContext(50 * time.Millisecond)
+	defer cancel()
+
+	if err := limiter.acquire(ctx); err != nil {
+		t.Fatalf("first acquire() error = %v, want nil", err)
+	}
+
+	defer limiter.release()
+
+	blockedCtx, blockedCancel := acquireContext(50 * time.Millisecond)
+	defer blockedCancel()
+
+	if err := limiter.acquire(blockedCtx); err == nil {
+		t.Fatal("second acquire() on a saturated limiter with a short timeout succeeded, want an error")
+	}
+
+	if got := limiter.Counters().Rejected; got != 1 {
+		t.Fatalf("Counters().Rejected = %d, want 1", got)
+	}
+}
+
+func TestAcquireContextNonPositiveTimeoutWaitsIndefinitely(t *testing.T) {
+	ctx, cancel := acquireContext(0)
+	defer cancel()
+
+	if ctx.Err() != nil {
+		t.Fatalf("acquireContext(0) returned an already-done context: %v", ctx.Err())
+	}
+
+	if _, ok := ctx.Deadline(); ok {
+		t.Fatal("acquireContext(0) should not set a deadline")
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+// EPSVPreference is a per-session flag tracking whether a client has
+// negotiated RFC 2428 extended mode, so a driver's FEAT handler knows
+// whether to advertise "EPSV" and prefer newExtendedPassiveSocket/
+// newExtendedActiveSocket over the plain PASV/PORT forms for the rest of
+// that session's data connections.
+type EPSVPreference struct {
+	enabled bool
+}
+
+// Enable marks the session as EPSV-capable - e.g. once it has issued an
+// EPSV/EPRT command, or the control connection itself is IPv6, which PASV/
+// PORT can't describe at all. Enable on a nil *EPSVPreference is a no-op,
+// matching Enabled's nil handling, rather than panicking on a session that
+// predates this type.
+func (pref *EPSVPreference) Enable() {
+	if pref == nil {
+		return
+	}
+
+	pref.enabled = true
+}
+
+// Enabled reports whether EPSV has been negotiated for this session. A nil
+// *EPSVPreference behaves as not-yet-negotiated, so sessions that predate
+// this type keep the historical PASV/PORT-only behaviour.
+func (pref *EPSVPreference) Enabled() bool {
+	return pref != nil && pref.enabled
+}
+
+// epsvFeatLine is the line a FEAT reply should include to advertise RFC
+// 2428 support to clients that probe for it.
+const epsvFeatLine = "EPSV"
+
+// FeatLines returns the extension lines a driver's FEAT handler should add
+// on top of its own, given the session's EPSV preference.
+func FeatLines(pref *EPSVPreference) []string {
+	if !pref.Enabled() {
+		return nil
+	}
+
+	return []string{epsvFeatLine}
+}
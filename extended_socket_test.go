@@ -0,0 +1,119 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type discardLogger struct{}
+
+func (discardLogger) Print(sessionID string, message interface{}) {}
+func (discardLogger) PrintData(sessionID string, event DataEvent) {}
+
+func TestNewExtendedActiveSocketParseErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		eprt string
+	}{
+		{"empty", ""},
+		{"missing fields", "|1|132.235.1.2|"},
+		{"too many fields", "|1|132.235.1.2|6275|extra|"},
+		{"unsupported protocol", "|3|132.235.1.2|6275|"},
+		{"non-numeric port", "|1|132.235.1.2|notaport|"},
+		{"no pipes", "1,132,235,1,2,24,131"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			socket, err := newExtendedActiveSocket(tc.eprt, discardLogger{}, "sess", nil, nil)
+
+			if err == nil {
+				t.Fatalf("newExtendedActiveSocket(%q) error = nil, want an error", tc.eprt)
+			}
+
+			if socket != nil {
+				t.Fatalf("newExtendedActiveSocket(%q) socket = %v, want nil", tc.eprt, socket)
+			}
+		})
+	}
+}
+
+func TestNewExtendedActiveSocketIPv4(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("failed to start listener: %v", err)
+	}
+
+	defer listener.Close()
+
+	go acceptOnce(listener)
+
+	_, portString, _ := net.SplitHostPort(listener.Addr().String())
+	eprt := "|1|127.0.0.1|" + portString + "|"
+
+	socket, err := newExtendedActiveSocket(eprt, discardLogger{}, "sess", nil, nil)
+
+	if err != nil {
+		t.Fatalf("newExtendedActiveSocket(%q) error = %v", eprt, err)
+	}
+
+	defer socket.Close()
+
+	wantPort, _ := strconv.Atoi(portString)
+
+	if socket.Port() != wantPort {
+		t.Fatalf("socket.Port() = %d, want %d", socket.Port(), wantPort)
+	}
+}
+
+func TestNewExtendedActiveSocketIPv6(t *testing.T) {
+	listener, err := net.Listen("tcp6", "[::1]:0")
+
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable in this environment: %v", err)
+	}
+
+	defer listener.Close()
+
+	go acceptOnce(listener)
+
+	_, portString, _ := net.SplitHostPort(listener.Addr().String())
+	eprt := "|2|::1|" + portString + "|"
+
+	socket, err := newExtendedActiveSocket(eprt, discardLogger{}, "sess", nil, nil)
+
+	if err != nil {
+		t.Fatalf("newExtendedActiveSocket(%q) error = %v", eprt, err)
+	}
+
+	defer socket.Close()
+}
+
+func acceptOnce(listener net.Listener) {
+	conn, err := listener.Accept()
+
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+}
+
+func TestExtendedPassiveReplyFormat(t *testing.T) {
+	socket, err := newExtendedPassiveSocket("127.0.0.1", 0, discardLogger{}, "sess", nil, nil, nil, nil)
+
+	if err != nil {
+		t.Fatalf("newExtendedPassiveSocket() error = %v", err)
+	}
+
+	defer socket.Close()
+
+	reply := ExtendedPassiveReply(socket)
+
+	if !strings.HasPrefix(reply, "(|||") || !strings.HasSuffix(reply, "|)") {
+		t.Fatalf("ExtendedPassiveReply() = %q, want the (|||port|) triple", reply)
+	}
+}
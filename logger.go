@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Logger is implemented by types that want visibility into what a session
+// is doing. Print receives a loosely-typed message so callers can log
+// plain strings as well as errors without stringifying them first.
+type Logger interface {
+	Print(sessionID string, message interface{})
+
+	// PrintData reports a data-connection lifecycle event - open, close,
+	// or error - with enough detail to correlate it back to the session
+	// it belongs to.
+	PrintData(sessionID string, event DataEvent)
+}
+
+// DataEventKind identifies what happened to a data connection.
+type DataEventKind int
+
+const (
+	DataEventOpen DataEventKind = iota
+	DataEventClose
+	DataEventError
+)
+
+func (kind DataEventKind) String() string {
+	switch kind {
+	case DataEventOpen:
+		return "open"
+	case DataEventClose:
+		return "close"
+	case DataEventError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// DataDirection describes which way bytes moved on a data connection.
+type DataDirection int
+
+const (
+	DataDirectionNone DataDirection = iota
+	DataDirectionUpload
+	DataDirectionDownload
+)
+
+func (direction DataDirection) String() string {
+	switch direction {
+	case DataDirectionUpload:
+		return "upload"
+	case DataDirectionDownload:
+		return "download"
+	default:
+		return "none"
+	}
+}
+
+// dataEventDirection infers the direction bytes moved on a data
+// connection from its final byte counters, since DataSocket itself has no
+// notion of which FTP command (STOR/RETR) opened it. A connection that
+// only ever saw one side of Read/Write is reported as that direction;
+// anything else (including an idle connection) is reported as none rather
+// than guessed at.
+func dataEventDirection(bytesRead, bytesWritten int64) DataDirection {
+	switch {
+	case bytesWritten > 0 && bytesRead == 0:
+		return DataDirectionDownload
+	case bytesRead > 0 && bytesWritten == 0:
+		return DataDirectionUpload
+	default:
+		return DataDirectionNone
+	}
+}
+
+// DataEvent describes a single data-connection lifecycle event - this is
+// what replaces the fmt.Println debug prints that used to live in
+// socket.go.
+type DataEvent struct {
+	Kind       DataEventKind
+	Direction  DataDirection
+	RemoteAddr string
+	Port       int
+	Bytes      int64
+	Elapsed    time.Duration
+	Err        error
+}
+
+// StdLogger is the default Logger, formatting to the standard log package
+// the way this module always has.
+type StdLogger struct{}
+
+// Print implements Logger.
+func (logger *StdLogger) Print(sessionID string, message interface{}) {
+	log.Printf("%s  %v", sessionID, message)
+}
+
+// PrintData implements Logger.
+func (logger *StdLogger) PrintData(sessionID string, event DataEvent) {
+	msg := fmt.Sprintf("%s data connection port=%d", event.Kind, event.Port)
+
+	if event.RemoteAddr != "" {
+		msg += " remote=" + event.RemoteAddr
+	}
+
+	if event.Direction != DataDirectionNone {
+		msg += " direction=" + event.Direction.String()
+	}
+
+	if event.Kind == DataEventClose {
+		msg += fmt.Sprintf(" bytes=%d elapsed=%s", event.Bytes, event.Elapsed)
+	}
+
+	if event.Err != nil {
+		msg += " err=" + event.Err.Error()
+	}
+
+	log.Printf("%s  %s", sessionID, msg)
+}
+
+// JSONLogger formats Print and PrintData as JSON lines, suitable for
+// shipping to a log aggregator.
+type JSONLogger struct{}
+
+// Print implements Logger.
+func (logger *JSONLogger) Print(sessionID string, message interface{}) {
+	logger.emit(map[string]interface{}{
+		"session_id": sessionID,
+		"message":    fmt.Sprint(message),
+	})
+}
+
+// PrintData implements Logger.
+func (logger *JSONLogger) PrintData(sessionID string, event DataEvent) {
+	fields := map[string]interface{}{
+		"session_id": sessionID,
+		"event":      event.Kind.String(),
+		"port":       event.Port,
+	}
+
+	if event.RemoteAddr != "" {
+		fields["remote_addr"] = event.RemoteAddr
+	}
+
+	if event.Direction != DataDirectionNone {
+		fields["direction"] = event.Direction.String()
+	}
+
+	if event.Kind == DataEventClose {
+		fields["bytes"] = event.Bytes
+		fields["elapsed_ms"] = event.Elapsed.Milliseconds()
+	}
+
+	if event.Err != nil {
+		fields["err"] = event.Err.Error()
+	}
+
+	logger.emit(fields)
+}
+
+func (logger *JSONLogger) emit(fields map[string]interface{}) {
+	line, err := json.Marshal(fields)
+
+	if err != nil {
+		return
+	}
+
+	fmt.Println(string(line))
+}
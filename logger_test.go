@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+
+	if err != nil {
+		t.Fatalf("os.Pipe() error = %v", err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	log.SetOutput(w)
+
+	defer func() {
+		os.Stdout = stdout
+		log.SetOutput(stdout)
+	}()
+
+	fn()
+
+	w.Close()
+
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+
+	return buf.String()
+}
+
+func TestJSONLoggerPrintDataOpenEvent(t *testing.T) {
+	line := captureStdout(t, func() {
+		(&JSONLogger{}).PrintData("sess-1", DataEvent{
+			Kind:       DataEventOpen,
+			RemoteAddr: "10.0.0.1:6275",
+			Port:       6275,
+		})
+	})
+
+	var fields map[string]interface{}
+
+	if err := json.Unmarshal([]byte(strings.TrimSpace(line)), &fields); err != nil {
+		t.Fatalf("PrintData output is not valid JSON: %v (line=%q)", err, line)
+	}
+
+	if fields["session_id"] != "sess-1" {
+		t.Fatalf("session_id = %v, want %q", fields["session_id"], "sess-1")
+	}
+
+	if fields["event"] != "open" {
+		t.Fatalf("event = %v, want %q", fields["event"], "open")
+	}
+
+	if fields["port"] != float64(6275) {
+		t.Fatalf("port = %v, want %v", fields["port"], 6275)
+	}
+
+	if fields["remote_addr"] != "10.0.0.1:6275" {
+		t.Fatalf("remote_addr = %v, want %q", fields["remote_addr"], "10.0.0.1:6275")
+	}
+
+	if _, ok := fields["bytes"]; ok {
+		t.Fatalf("open event should not include bytes/elapsed, got fields=%v", fields)
+	}
+}
+
+func TestJSONLoggerPrintDataCloseEvent(t *testing.T) {
+	line := captureStdout(t, func() {
+		(&JSONLogger{}).PrintData("sess-2", DataEvent{
+			Kind:      DataEventClose,
+			Direction: DataDirectionDownload,
+			Port:      6275,
+			Bytes:     1024,
+			Elapsed:   250 * time.Millisecond,
+			Err:       errors.New("connection reset"),
+		})
+	})
+
+	var fields map[string]interface{}
+
+	if err := json.Unmarshal([]byte(strings.TrimSpace(line)), &fields); err != nil {
+		t.Fatalf("PrintData output is not valid JSON: %v (line=%q)", err, line)
+	}
+
+	if fields["event"] != "close" {
+		t.Fatalf("event = %v, want %q", fields["event"], "close")
+	}
+
+	if fields["direction"] != "download" {
+		t.Fatalf("direction = %v, want %q", fields["direction"], "download")
+	}
+
+	if fields["bytes"] != float64(1024) {
+		t.Fatalf("bytes = %v, want %v", fields["bytes"], 1024)
+	}
+
+	if fields["elapsed_ms"] != float64(250) {
+		t.Fatalf("elapsed_ms = %v, want %v", fields["elapsed_ms"], 250)
+	}
+
+	if fields["err"] != "connection reset" {
+		t.Fatalf("err = %v, want %q", fields["err"], "connection reset")
+	}
+}
+
+func TestStdLoggerPrintDataIncludesDirectionAndErr(t *testing.T) {
+	output := captureStdout(t, func() {
+		(&StdLogger{}).PrintData("sess-3", DataEvent{
+			Kind:      DataEventClose,
+			Direction: DataDirectionUpload,
+			Port:      6275,
+			Bytes:     512,
+			Elapsed:   time.Second,
+			Err:       errors.New("timeout"),
+		})
+	})
+
+	for _, want := range []string{"sess-3", "port=6275", "direction=upload", "bytes=512", "err=timeout"} {
+		if !strings.Contains(output, want) {
+			t.Fatalf("StdLogger.PrintData() output = %q, want it to contain %q", output, want)
+		}
+	}
+}
+
+func TestDataEventDirection(t *testing.T) {
+	cases := []struct {
+		name         string
+		bytesRead    int64
+		bytesWritten int64
+		want         DataDirection
+	}{
+		{"upload only", 100, 0, DataDirectionUpload},
+		{"download only", 0, 100, DataDirectionDownload},
+		{"both directions", 50, 50, DataDirectionNone},
+		{"idle", 0, 0, DataDirectionNone},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := dataEventDirection(tc.bytesRead, tc.bytesWritten); got != tc.want {
+				t.Fatalf("dataEventDirection(%d, %d) = %v, want %v", tc.bytesRead, tc.bytesWritten, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// Pacer decides how long to wait before admitting the next data connection
+// attempt, giving the server a way to push back on a client that hammers it
+// with PASV/PORT commands instead of just queuing or rejecting outright.
+type Pacer interface {
+	Pace()
+}
+
+// FixedPacer sleeps for a constant Delay each time it is consulted.
+type FixedPacer struct {
+	Delay time.Duration
+}
+
+// Pace implements Pacer.
+func (p *FixedPacer) Pace() {
+	if p.Delay > 0 {
+		time.Sleep(p.Delay)
+	}
+}
+
+// ExponentialPacer backs off exponentially between attempts, capped at Max
+// and with full jitter, so that concurrent sessions don't retry in
+// lockstep - the same shape rclone's pacer uses for its remote backends.
+type ExponentialPacer struct {
+	Min time.Duration
+	Max time.Duration
+
+	attempt uint32
+}
+
+// Pace implements Pacer.
+func (p *ExponentialPacer) Pace() {
+	attempt := atomic.AddUint32(&p.attempt, 1) - 1
+
+	delay := p.Min << attempt
+
+	if delay <= 0 || delay > p.Max {
+		delay = p.Max
+	}
+
+	if delay <= 0 {
+		return
+	}
+
+	time.Sleep(time.Duration(rand.Int63n(int64(delay))))
+}
+
+// reset forgets any accumulated backoff, so the next Pace starts again from
+// Min. DataConnLimiter calls this after an uncontended acquire, so a pacer
+// that ratcheted up under a burst of load recovers once that load subsides
+// instead of staying paced at Max for the life of the process.
+func (p *ExponentialPacer) reset() {
+	atomic.StoreUint32(&p.attempt, 0)
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDataConnLimiterOnlyPacesUnderContention(t *testing.T) {
+	pacer := &ExponentialPacer{Min: time.Hour, Max: time.Hour}
+	limiter := NewDataConnLimiter(2, pacer)
+
+	// Two uncontended acquires (capacity is 2) must never touch the pacer,
+	// since Min is an hour this would otherwise hang the test.
+	if err := limiter.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	if err := limiter.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	limiter.release()
+	limiter.release()
+}
+
+func TestExponentialPacerResetRestartsFromMin(t *testing.T) {
+	pacer := &ExponentialPacer{Min: time.Millisecond, Max: time.Second}
+
+	// Ratchet the pacer up a few attempts.
+	for i := 0; i < 5; i++ {
+		pacer.Pace()
+	}
+
+	pacer.reset()
+
+	start := time.Now()
+	pacer.Pace()
+	elapsed := time.Since(start)
+
+	if elapsed > 50*time.Millisecond {
+		t.Fatalf("Pace() after reset() took %v, expected it to be back near Min (%v)", elapsed, pacer.Min)
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"fmt"
+	"net"
+)
+
+// PassiveOpts controls how passive (PASV/EPSV) listeners are bound and how
+// their address is reported back to clients, so the server can run behind
+// a firewall or NAT - in the spirit of the goftp/koofr passive-socket
+// design.
+type PassiveOpts struct {
+	// PortRange restricts passive listeners to [min, max] inclusive. The
+	// zero value lets the OS assign any free port, matching the historical
+	// behaviour.
+	PortRange [2]int
+
+	// PublicIP, when set, is reported to the client in the PASV/EPSV reply
+	// instead of the listener's local address.
+	PublicIP string
+
+	// NATLookup, when set, overrides PublicIP with an address resolved at
+	// connection time (e.g. querying a cloud provider's metadata
+	// endpoint). It takes priority over PublicIP.
+	NATLookup func() (string, error)
+}
+
+func (opts *PassiveOpts) hasPortRange() bool {
+	return opts != nil && (opts.PortRange[0] != 0 || opts.PortRange[1] != 0)
+}
+
+// publicHost resolves the address that should be reported to the client in
+// place of the listener's local host, or "" if neither NATLookup nor
+// PublicIP is configured.
+func (opts *PassiveOpts) publicHost() (string, error) {
+	if opts == nil {
+		return "", nil
+	}
+
+	if opts.NATLookup != nil {
+		return opts.NATLookup()
+	}
+
+	return opts.PublicIP, nil
+}
+
+// listenOnRange binds a TCP listener on the first free port in
+// opts.PortRange, trying each port in turn so a busy port doesn't fail the
+// whole passive connection attempt. With no range configured it falls back
+// to letting the OS pick any free port.
+func listenOnRange(opts *PassiveOpts) (*net.TCPListener, error) {
+	if !opts.hasPortRange() {
+		return net.ListenTCP("tcp", &net.TCPAddr{Port: 0})
+	}
+
+	min, max := opts.PortRange[0], opts.PortRange[1]
+
+	if min <= 0 || max <= 0 || min > max {
+		return nil, fmt.Errorf("invalid passive port range [%d, %d]", min, max)
+	}
+
+	var lastErr error
+
+	for port := min; port <= max; port++ {
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: port})
+
+		if err == nil {
+			return listener, nil
+		}
+
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("no free port in passive range [%d, %d]: %w", min, max, lastErr)
+}
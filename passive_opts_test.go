@@ -0,0 +1,138 @@
+package server
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenOnRangeNoRangeUsesAnyFreePort(t *testing.T) {
+	listener, err := listenOnRange(nil)
+
+	if err != nil {
+		t.Fatalf("listenOnRange() error = %v", err)
+	}
+
+	defer listener.Close()
+
+	if listener.Addr().(*net.TCPAddr).Port == 0 {
+		t.Fatal("expected an OS-assigned port, got 0")
+	}
+}
+
+func TestListenOnRangeBindsWithinRange(t *testing.T) {
+	probe, err := net.ListenTCP("tcp", &net.TCPAddr{Port: 0})
+
+	if err != nil {
+		t.Fatalf("failed to pick a free port for the test: %v", err)
+	}
+
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	opts := &PassiveOpts{PortRange: [2]int{port, port + 10}}
+
+	listener, err := listenOnRange(opts)
+
+	if err != nil {
+		t.Fatalf("listenOnRange() error = %v", err)
+	}
+
+	defer listener.Close()
+
+	bound := listener.Addr().(*net.TCPAddr).Port
+
+	if bound < port || bound > port+10 {
+		t.Fatalf("bound port %d outside range [%d, %d]", bound, port, port+10)
+	}
+}
+
+func TestListenOnRangeContentionFallsThroughToNextPort(t *testing.T) {
+	probe, err := net.ListenTCP("tcp", &net.TCPAddr{Port: 0})
+
+	if err != nil {
+		t.Fatalf("failed to pick a free port for the test: %v", err)
+	}
+
+	defer probe.Close()
+
+	port := probe.Addr().(*net.TCPAddr).Port
+
+	opts := &PassiveOpts{PortRange: [2]int{port, port + 1}}
+
+	listener, err := listenOnRange(opts)
+
+	if err != nil {
+		t.Fatalf("listenOnRange() error = %v", err)
+	}
+
+	defer listener.Close()
+
+	if bound := listener.Addr().(*net.TCPAddr).Port; bound != port+1 {
+		t.Fatalf("expected contention to fall through to port %d, got %d", port+1, bound)
+	}
+}
+
+func TestListenOnRangeExhaustion(t *testing.T) {
+	first, err := net.ListenTCP("tcp", &net.TCPAddr{Port: 0})
+
+	if err != nil {
+		t.Fatalf("failed to pick a free port for the test: %v", err)
+	}
+
+	defer first.Close()
+
+	port := first.Addr().(*net.TCPAddr).Port
+
+	opts := &PassiveOpts{PortRange: [2]int{port, port}}
+
+	if _, err := listenOnRange(opts); err == nil {
+		t.Fatal("expected an error when every port in the range is taken")
+	}
+}
+
+func TestPassiveOptsPublicHostIPv4Masquerade(t *testing.T) {
+	opts := &PassiveOpts{PublicIP: "203.0.113.10"}
+
+	host, err := opts.publicHost()
+
+	if err != nil {
+		t.Fatalf("publicHost() error = %v", err)
+	}
+
+	if host != "203.0.113.10" {
+		t.Fatalf("publicHost() = %q, want 203.0.113.10", host)
+	}
+}
+
+func TestPassiveOptsPublicHostIPv6Masquerade(t *testing.T) {
+	opts := &PassiveOpts{PublicIP: "2001:db8::1"}
+
+	host, err := opts.publicHost()
+
+	if err != nil {
+		t.Fatalf("publicHost() error = %v", err)
+	}
+
+	if host != "2001:db8::1" {
+		t.Fatalf("publicHost() = %q, want 2001:db8::1", host)
+	}
+}
+
+func TestPassiveOptsNATLookupTakesPriority(t *testing.T) {
+	opts := &PassiveOpts{
+		PublicIP: "203.0.113.10",
+		NATLookup: func() (string, error) {
+			return "198.51.100.2", nil
+		},
+	}
+
+	host, err := opts.publicHost()
+
+	if err != nil {
+		t.Fatalf("publicHost() error = %v", err)
+	}
+
+	if host != "198.51.100.2" {
+		t.Fatalf("publicHost() = %q, want NATLookup result", host)
+	}
+}
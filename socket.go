@@ -2,14 +2,21 @@ package server
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// errDataConnClosed is returned by waitForOpenSocket when Close() won the
+// race against the pending Accept, so a subsequent Read/Write gets a
+// regular error instead of finding conn still nil.
+var errDataConnClosed = errors.New("use of closed data connection")
+
 // A data socket is used to send non-control data between the client and
 // server.
 type DataSocket interface {
@@ -25,16 +32,57 @@ type DataSocket interface {
 
 	// the standard io.Closer interface
 	Close() error
+
+	// SetDeadline sets the read and write deadlines on the underlying
+	// connection, the way (*jlaffaye/ftp.Response).SetDeadline does for
+	// client-side transfers.
+	SetDeadline(t time.Time) error
+
+	// SetReadDeadline sets the deadline for future Read calls.
+	SetReadDeadline(t time.Time) error
+
+	// SetWriteDeadline sets the deadline for future Write calls.
+	SetWriteDeadline(t time.Time) error
+
+	// BytesRead returns the number of bytes read from the client so far,
+	// so drivers can report accurate transfer sizes in the FTP 226 reply.
+	BytesRead() int64
+
+	// BytesWritten returns the number of bytes written to the client so
+	// far.
+	BytesWritten() int64
 }
 
 type ftpActiveSocket struct {
-	conn   net.Conn
-	host   string
-	port   int
-	logger Logger
+	conn      net.Conn
+	host      string
+	port      int
+	logger    Logger
+	sessionID string
+	config    *DataConnConfig
+	limiter   *DataConnLimiter
+	openedAt  time.Time
+
+	bytesRead    int64
+	bytesWritten int64
+
+	// explicitDeadline is set once a caller uses SetDeadline/
+	// SetReadDeadline/SetWriteDeadline, so applyIdleDeadline stops
+	// overwriting whatever transfer-level deadline they configured.
+	explicitDeadline int32
 }
 
-func newActiveSocket(remote string, port int, logger Logger, sessionID string) (DataSocket, error) {
+func newActiveSocket(remote string, port int, logger Logger, sessionID string, config *DataConnConfig, limiter *DataConnLimiter) (DataSocket, error) {
+	config = dataConnConfigOrDefault(config)
+
+	acquireCtx, cancel := acquireContext(config.DialTimeout)
+	defer cancel()
+
+	if err := limiter.acquire(acquireCtx); err != nil {
+		logger.Print(sessionID, err)
+		return nil, err
+	}
+
 	connectTo := net.JoinHostPort(remote, strconv.Itoa(port))
 
 	logger.Print(sessionID, "Opening active data connection to "+connectTo)
@@ -43,13 +91,17 @@ func newActiveSocket(remote string, port int, logger Logger, sessionID string) (
 
 	if err != nil {
 		logger.Print(sessionID, err)
+		limiter.release()
 		return nil, err
 	}
 
-	tcpConn, err := net.DialTimeout("tcp", raddr.String(), 15*time.Second)
+	dialer := net.Dialer{Timeout: config.DialTimeout, KeepAlive: config.Keepalive}
+
+	tcpConn, err := dialer.Dial("tcp", raddr.String())
 
 	if err != nil {
 		logger.Print(sessionID, err)
+		limiter.release()
 		return nil, err
 	}
 
@@ -58,8 +110,12 @@ func newActiveSocket(remote string, port int, logger Logger, sessionID string) (
 	socket.host = remote
 	socket.port = port
 	socket.logger = logger
+	socket.sessionID = sessionID
+	socket.config = config
+	socket.limiter = limiter
+	socket.openedAt = time.Now()
 
-	fmt.Println("open", socket.port)
+	logger.PrintData(sessionID, DataEvent{Kind: DataEventOpen, RemoteAddr: connectTo, Port: socket.port})
 
 	return socket, nil
 }
@@ -73,47 +129,146 @@ func (socket *ftpActiveSocket) Port() int {
 }
 
 func (socket *ftpActiveSocket) Read(p []byte) (n int, err error) {
-	return socket.conn.Read(p)
+	if err := socket.applyIdleDeadline(); err != nil {
+		return 0, err
+	}
+
+	n, err = socket.conn.Read(p)
+	atomic.AddInt64(&socket.bytesRead, int64(n))
+
+	return n, err
 }
 
 func (socket *ftpActiveSocket) Write(p []byte) (n int, err error) {
-	return socket.conn.Write(p)
+	if err := socket.applyIdleDeadline(); err != nil {
+		return 0, err
+	}
+
+	n, err = socket.conn.Write(p)
+	atomic.AddInt64(&socket.bytesWritten, int64(n))
+
+	return n, err
 }
 
 func (socket *ftpActiveSocket) Close() error {
-	fmt.Println("close", socket.port)
+	defer socket.limiter.release()
+
+	bytesRead, bytesWritten := socket.BytesRead(), socket.BytesWritten()
+
+	socket.logger.PrintData(socket.sessionID, DataEvent{
+		Kind:      DataEventClose,
+		Direction: dataEventDirection(bytesRead, bytesWritten),
+		Port:      socket.port,
+		Bytes:     bytesRead + bytesWritten,
+		Elapsed:   time.Since(socket.openedAt),
+	})
+
 	return socket.conn.Close()
 }
 
+func (socket *ftpActiveSocket) BytesRead() int64 {
+	return atomic.LoadInt64(&socket.bytesRead)
+}
+
+func (socket *ftpActiveSocket) BytesWritten() int64 {
+	return atomic.LoadInt64(&socket.bytesWritten)
+}
+
+func (socket *ftpActiveSocket) SetDeadline(t time.Time) error {
+	atomic.StoreInt32(&socket.explicitDeadline, 1)
+	return socket.conn.SetDeadline(t)
+}
+
+func (socket *ftpActiveSocket) SetReadDeadline(t time.Time) error {
+	atomic.StoreInt32(&socket.explicitDeadline, 1)
+	return socket.conn.SetReadDeadline(t)
+}
+
+func (socket *ftpActiveSocket) SetWriteDeadline(t time.Time) error {
+	atomic.StoreInt32(&socket.explicitDeadline, 1)
+	return socket.conn.SetWriteDeadline(t)
+}
+
+// applyIdleDeadline rolls the deadline forward by config.IdleTimeout before
+// every Read/Write, unless the caller has taken over deadline management
+// via SetDeadline/SetReadDeadline/SetWriteDeadline - the two are mutually
+// exclusive, since a rolling idle deadline would otherwise clobber whatever
+// transfer-level deadline the caller just set.
+func (socket *ftpActiveSocket) applyIdleDeadline() error {
+	if socket.config == nil || socket.config.IdleTimeout == 0 {
+		return nil
+	}
+
+	if atomic.LoadInt32(&socket.explicitDeadline) != 0 {
+		return nil
+	}
+
+	return socket.conn.SetDeadline(time.Now().Add(socket.config.IdleTimeout))
+}
+
 type ftpPassiveSocket struct {
+	mu        sync.RWMutex
+	listener  *net.TCPListener
 	conn      net.Conn
+	closed    bool
+	connected chan struct{}
+
 	port      int
 	host      string
-	ingress   chan []byte
-	egress    chan []byte
 	logger    Logger
-	wg        sync.WaitGroup
+	sessionID string
 	err       error
 	tlsConfig *tls.Config
+	config    *DataConnConfig
+	opts      *PassiveOpts
+	limiter   *DataConnLimiter
+	openedAt  time.Time
+
+	bytesRead    int64
+	bytesWritten int64
+
+	// explicitDeadline is set once a caller uses SetDeadline/
+	// SetReadDeadline/SetWriteDeadline, so applyIdleDeadline stops
+	// overwriting whatever transfer-level deadline they configured.
+	explicitDeadline int32
 }
 
-func newPassiveSocket(host string, port int, logger Logger, sessionID string, tlsConfing *tls.Config) (DataSocket, error) {
+func newPassiveSocket(host string, port int, logger Logger, sessionID string, tlsConfing *tls.Config, config *DataConnConfig, opts *PassiveOpts, limiter *DataConnLimiter) (DataSocket, error) {
+	config = dataConnConfigOrDefault(config)
+
+	acquireCtx, cancel := acquireContext(config.PassiveAcceptTimeout)
+	defer cancel()
+
+	if err := limiter.acquire(acquireCtx); err != nil {
+		logger.Print(sessionID, err)
+		return nil, err
+	}
+
 	socket := new(ftpPassiveSocket)
-	socket.ingress = make(chan []byte)
-	socket.egress = make(chan []byte)
+	socket.connected = make(chan struct{})
 	socket.logger = logger
+	socket.sessionID = sessionID
 	socket.host = host
 	socket.port = port
+	socket.config = config
+	socket.opts = opts
+	socket.limiter = limiter
 	if err := socket.GoListenAndServe(sessionID); err != nil {
+		limiter.release()
 		return nil, err
 	}
 
-	fmt.Println("open", socket.port)
+	socket.openedAt = time.Now()
+	logger.PrintData(sessionID, DataEvent{Kind: DataEventOpen, Port: socket.port})
 
 	return socket, nil
 }
 
 func (socket *ftpPassiveSocket) Host() string {
+	if host, err := socket.opts.publicHost(); err == nil && host != "" {
+		return host
+	}
+
 	return socket.host
 }
 
@@ -128,7 +283,14 @@ func (socket *ftpPassiveSocket) Read(p []byte) (int, error) {
 		return 0, err
 	}
 
-	return socket.conn.Read(p)
+	if err := socket.applyIdleDeadline(); err != nil {
+		return 0, err
+	}
+
+	n, err := socket.getConn().Read(p)
+	atomic.AddInt64(&socket.bytesRead, int64(n))
+
+	return n, err
 }
 
 func (socket *ftpPassiveSocket) Write(p []byte) (int, error) {
@@ -138,76 +300,243 @@ func (socket *ftpPassiveSocket) Write(p []byte) (int, error) {
 		return 0, err
 	}
 
-	return socket.conn.Write(p)
+	if err := socket.applyIdleDeadline(); err != nil {
+		return 0, err
+	}
+
+	n, err := socket.getConn().Write(p)
+	atomic.AddInt64(&socket.bytesWritten, int64(n))
+
+	return n, err
+}
+
+func (socket *ftpPassiveSocket) BytesRead() int64 {
+	return atomic.LoadInt64(&socket.bytesRead)
 }
 
+func (socket *ftpPassiveSocket) BytesWritten() int64 {
+	return atomic.LoadInt64(&socket.bytesWritten)
+}
+
+// Close is idempotent: it may be called concurrently with a pending Accept
+// or an in-flight Read/Write. If the connection hasn't been accepted yet it
+// closes the listener so Accept() unblocks instead of leaking it.
 func (socket *ftpPassiveSocket) Close() error {
-	if socket.conn != nil {
-		fmt.Println("close", socket.port)
-		return socket.conn.Close()
+	socket.mu.Lock()
+
+	if socket.closed {
+		socket.mu.Unlock()
+		return nil
 	}
 
-	return nil
+	socket.closed = true
+	listener := socket.listener
+	conn := socket.conn
+	socket.mu.Unlock()
+
+	defer socket.limiter.release()
+
+	var err error
+
+	if listener != nil {
+		err = listener.Close()
+	}
+
+	if conn != nil {
+		bytesRead, bytesWritten := socket.BytesRead(), socket.BytesWritten()
+
+		socket.logger.PrintData(socket.sessionID, DataEvent{
+			Kind:      DataEventClose,
+			Direction: dataEventDirection(bytesRead, bytesWritten),
+			Port:      socket.port,
+			Bytes:     bytesRead + bytesWritten,
+			Elapsed:   time.Since(socket.openedAt),
+		})
+
+		if closeErr := conn.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+func (socket *ftpPassiveSocket) SetDeadline(t time.Time) error {
+	if err := socket.waitForOpenSocket(); err != nil {
+		return err
+	}
+
+	atomic.StoreInt32(&socket.explicitDeadline, 1)
+	return socket.getConn().SetDeadline(t)
+}
+
+func (socket *ftpPassiveSocket) SetReadDeadline(t time.Time) error {
+	if err := socket.waitForOpenSocket(); err != nil {
+		return err
+	}
+
+	atomic.StoreInt32(&socket.explicitDeadline, 1)
+	return socket.getConn().SetReadDeadline(t)
+}
+
+func (socket *ftpPassiveSocket) SetWriteDeadline(t time.Time) error {
+	if err := socket.waitForOpenSocket(); err != nil {
+		return err
+	}
+
+	atomic.StoreInt32(&socket.explicitDeadline, 1)
+	return socket.getConn().SetWriteDeadline(t)
+}
+
+// applyIdleDeadline rolls the deadline forward by config.IdleTimeout before
+// every Read/Write, unless the caller has taken over deadline management
+// via SetDeadline/SetReadDeadline/SetWriteDeadline - the two are mutually
+// exclusive, since a rolling idle deadline would otherwise clobber whatever
+// transfer-level deadline the caller just set.
+func (socket *ftpPassiveSocket) applyIdleDeadline() error {
+	if socket.config == nil || socket.config.IdleTimeout == 0 {
+		return nil
+	}
+
+	if atomic.LoadInt32(&socket.explicitDeadline) != 0 {
+		return nil
+	}
+
+	return socket.getConn().SetDeadline(time.Now().Add(socket.config.IdleTimeout))
+}
+
+func (socket *ftpPassiveSocket) getConn() net.Conn {
+	socket.mu.RLock()
+	defer socket.mu.RUnlock()
+
+	return socket.conn
 }
 
 func (socket *ftpPassiveSocket) GoListenAndServe(sessionID string) (err error) {
-	laddr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort("", strconv.Itoa(socket.port)))
+	var tcpListener *net.TCPListener
+
+	tcpListener, err = listenOnRange(socket.opts)
 
 	if err != nil {
 		socket.logger.Print(sessionID, err)
 		return
 	}
 
-	var listener net.Listener
+	var listener net.Listener = tcpListener
 
-	listener, err = net.ListenTCP("tcp", laddr)
+	_, portString, err := net.SplitHostPort(listener.Addr().String())
 
 	if err != nil {
 		socket.logger.Print(sessionID, err)
+		tcpListener.Close()
 		return
 	}
 
-	add := listener.Addr()
-	parts := strings.Split(add.String(), ":")
-	port, err := strconv.Atoi(parts[len(parts)-1])
+	port, err := strconv.Atoi(portString)
 
 	if err != nil {
 		socket.logger.Print(sessionID, err)
+		tcpListener.Close()
 		return
 	}
 
 	socket.port = port
-	socket.wg.Add(1)
 
 	if socket.tlsConfig != nil {
 		listener = tls.NewListener(listener, socket.tlsConfig)
 	}
 
+	socket.mu.Lock()
+	socket.listener = tcpListener
+	socket.mu.Unlock()
+
 	go func() {
-		listener.(*net.TCPListener).SetDeadline(time.Now().Add(15 * time.Second))
+		tcpListener.SetDeadline(time.Now().Add(socket.config.PassiveAcceptTimeout))
 
-		conn, err := listener.Accept()
+		conn, acceptErr := listener.Accept()
 
-		defer socket.wg.Done()
+		socket.mu.Lock()
+		if !socket.closed {
+			socket.err = acceptErr
+			socket.conn = conn
+		} else {
+			socket.err = errDataConnClosed
 
-		if err != nil {
-			socket.err = err
-			return
+			if conn != nil {
+				conn.Close()
+			}
 		}
+		socket.mu.Unlock()
 
-		socket.err = nil
-		socket.conn = conn
+		close(socket.connected)
 	}()
 
 	return nil
 }
 
 func (socket *ftpPassiveSocket) waitForOpenSocket() error {
-	if socket.conn != nil {
+	socket.mu.RLock()
+	conn := socket.conn
+	socket.mu.RUnlock()
+
+	if conn != nil {
 		return nil
 	}
 
-	socket.wg.Wait()
+	<-socket.connected
+
+	socket.mu.RLock()
+	defer socket.mu.RUnlock()
 
 	return socket.err
 }
+
+// newExtendedPassiveSocket opens a passive data connection for the RFC 2428
+// EPSV command. It behaves exactly like newPassiveSocket - the only
+// difference is in how the caller reports the listening port back to the
+// client, see ExtendedPassiveReply.
+func newExtendedPassiveSocket(host string, port int, logger Logger, sessionID string, tlsConfig *tls.Config, config *DataConnConfig, opts *PassiveOpts, limiter *DataConnLimiter) (DataSocket, error) {
+	return newPassiveSocket(host, port, logger, sessionID, tlsConfig, config, opts, limiter)
+}
+
+// ExtendedPassiveReply formats the "(|||port|)" triple that an EPSV reply
+// (RFC 2428 section 3) sends back to the client, leaving the protocol and
+// address empty since the client is expected to reuse the control
+// connection's address family.
+func ExtendedPassiveReply(socket DataSocket) string {
+	return fmt.Sprintf("(|||%d|)", socket.Port())
+}
+
+// newExtendedActiveSocket creates an active data connection from the
+// argument of an RFC 2428 EPRT command, e.g. "|1|132.235.1.2|6275|" for
+// IPv4 or "|2|::1|6275|" for IPv6.
+func newExtendedActiveSocket(eprt string, logger Logger, sessionID string, config *DataConnConfig, limiter *DataConnLimiter) (DataSocket, error) {
+	parts := strings.Split(eprt, "|")
+
+	// "|1|132.235.1.2|6275|" splits into ["", "1", "132.235.1.2", "6275", ""]
+	if len(parts) != 5 {
+		err := fmt.Errorf("malformed EPRT argument: %q", eprt)
+		logger.Print(sessionID, err)
+		return nil, err
+	}
+
+	proto, addr, portString := parts[1], parts[2], parts[3]
+
+	switch proto {
+	case "1", "2":
+		// net family 1 (IPv4) and 2 (IPv6) are the only ones RFC 2428 defines.
+	default:
+		err := fmt.Errorf("unsupported EPRT network protocol %q", proto)
+		logger.Print(sessionID, err)
+		return nil, err
+	}
+
+	port, err := strconv.Atoi(portString)
+
+	if err != nil {
+		logger.Print(sessionID, err)
+		return nil, err
+	}
+
+	return newActiveSocket(addr, port, logger, sessionID, config, limiter)
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPassiveSocketCloseBeforeAcceptThenReadReturnsError(t *testing.T) {
+	config := &DataConnConfig{PassiveAcceptTimeout: 5 * time.Second}
+
+	socket, err := newPassiveSocket("127.0.0.1", 0, discardLogger{}, "sess", nil, config, nil, nil)
+
+	if err != nil {
+		t.Fatalf("newPassiveSocket() error = %v", err)
+	}
+
+	// No client ever connects, so the accept goroutine is still blocked in
+	// Accept() when Close() runs - this is the race the fix covers.
+	if err := socket.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	buf := make([]byte, 16)
+
+	if _, err := socket.Read(buf); err == nil {
+		t.Fatal("Read() after Close()-before-Accept returned nil error, want an error")
+	}
+
+	if _, err := socket.Write(buf); err == nil {
+		t.Fatal("Write() after Close()-before-Accept returned nil error, want an error")
+	}
+}
+
+func TestPassiveSocketCloseIsIdempotent(t *testing.T) {
+	config := &DataConnConfig{PassiveAcceptTimeout: 5 * time.Second}
+
+	socket, err := newPassiveSocket("127.0.0.1", 0, discardLogger{}, "sess", nil, config, nil, nil)
+
+	if err != nil {
+		t.Fatalf("newPassiveSocket() error = %v", err)
+	}
+
+	if err := socket.Close(); err != nil {
+		t.Fatalf("first Close() error = %v", err)
+	}
+
+	if err := socket.Close(); err != nil {
+		t.Fatalf("second Close() error = %v, want nil (idempotent)", err)
+	}
+}
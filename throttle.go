@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+)
+
+// Throttle bounds the bandwidth a data connection is allowed to use,
+// expressed in bytes/sec. A zero Bps means unlimited. It can describe a
+// Server-wide cap shared by every session, or a per-session override of
+// that cap - see NewGlobalThrottle and NewSessionThrottle.
+type Throttle struct {
+	// UploadBps caps how fast the server may read data the client is
+	// sending it (STOR and friends).
+	UploadBps int64
+
+	// DownloadBps caps how fast the server may write data out to the
+	// client (RETR and friends).
+	DownloadBps int64
+}
+
+func newLimiter(bps int64) *rate.Limiter {
+	if bps <= 0 {
+		return nil
+	}
+
+	return rate.NewLimiter(rate.Limit(bps), int(bps))
+}
+
+// NewGlobalThrottle builds a pair of limiters meant to be shared across
+// every session on the server, so all transfers compete for one bandwidth
+// budget. Pass the same readLimiter/writeLimiter to every throttledSocket.
+func NewGlobalThrottle(t Throttle) (readLimiter, writeLimiter *rate.Limiter) {
+	return newLimiter(t.UploadBps), newLimiter(t.DownloadBps)
+}
+
+// NewSessionThrottle builds a limiter pair private to a single connection,
+// overriding the server-wide Throttle for just that session.
+func NewSessionThrottle(t Throttle) (readLimiter, writeLimiter *rate.Limiter) {
+	return newLimiter(t.UploadBps), newLimiter(t.DownloadBps)
+}
+
+// throttledSocket wraps a DataSocket so Read/Write are bounded by a
+// token-bucket rate.Limiter. The limiters are held behind atomic.Value so
+// they can be swapped out while the socket is in use - e.g. an operator
+// changing a live session's cap through a management API without kicking
+// it - without the caller needing to synchronize with in-flight Read/Write
+// calls.
+type throttledSocket struct {
+	DataSocket
+
+	readLimiter  atomic.Value // *rate.Limiter
+	writeLimiter atomic.Value // *rate.Limiter
+}
+
+// newThrottledSocket wraps socket with the given limiters. Either may be
+// nil for "no limit in that direction".
+func newThrottledSocket(socket DataSocket, readLimiter, writeLimiter *rate.Limiter) *throttledSocket {
+	throttled := &throttledSocket{DataSocket: socket}
+	throttled.SetReadLimiter(readLimiter)
+	throttled.SetWriteLimiter(writeLimiter)
+
+	return throttled
+}
+
+// SetReadLimiter swaps the limiter applied to Read, effective immediately.
+func (socket *throttledSocket) SetReadLimiter(l *rate.Limiter) {
+	socket.readLimiter.Store(limiterBox{l})
+}
+
+// SetWriteLimiter swaps the limiter applied to Write, effective immediately.
+func (socket *throttledSocket) SetWriteLimiter(l *rate.Limiter) {
+	socket.writeLimiter.Store(limiterBox{l})
+}
+
+// limiterBox wraps a *rate.Limiter so atomic.Value always sees the same
+// concrete type across Store calls, even when the limiter itself is nil.
+type limiterBox struct {
+	limiter *rate.Limiter
+}
+
+func (socket *throttledSocket) currentReadLimiter() *rate.Limiter {
+	box, _ := socket.readLimiter.Load().(limiterBox)
+	return box.limiter
+}
+
+func (socket *throttledSocket) currentWriteLimiter() *rate.Limiter {
+	box, _ := socket.writeLimiter.Load().(limiterBox)
+	return box.limiter
+}
+
+func (socket *throttledSocket) Read(p []byte) (int, error) {
+	n, err := socket.DataSocket.Read(p)
+
+	if n > 0 {
+		if waitErr := waitN(socket.currentReadLimiter(), n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
+
+func (socket *throttledSocket) Write(p []byte) (int, error) {
+	n, err := socket.DataSocket.Write(p)
+
+	if n > 0 {
+		if waitErr := waitN(socket.currentWriteLimiter(), n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
+
+// waitN blocks until the limiter has released n tokens, chunking the
+// request to stay within the limiter's burst size. A nil limiter never
+// blocks.
+func waitN(limiter *rate.Limiter, n int) error {
+	if limiter == nil {
+		return nil
+	}
+
+	ctx := context.Background()
+	burst := limiter.Burst()
+
+	for n > 0 {
+		take := n
+
+		if burst > 0 && take > burst {
+			take = burst
+		}
+
+		if err := limiter.WaitN(ctx, take); err != nil {
+			return err
+		}
+
+		n -= take
+	}
+
+	return nil
+}
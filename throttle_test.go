@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// connDataSocket adapts a net.Conn to DataSocket for tests - net.Conn
+// already satisfies Read/Write/Close/SetDeadline/SetReadDeadline/
+// SetWriteDeadline with matching signatures.
+type connDataSocket struct {
+	net.Conn
+}
+
+func (connDataSocket) Host() string        { return "127.0.0.1" }
+func (connDataSocket) Port() int           { return 0 }
+func (connDataSocket) BytesRead() int64    { return 0 }
+func (connDataSocket) BytesWritten() int64 { return 0 }
+
+func TestThrottledSocketWriteRespectsBandwidthCap(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	const bps = 8 * 1024 // 8 KiB/s
+	const burst = 1024   // small burst so most of the transfer is rate-limited
+	const payload = 16 * 1024
+
+	writeLimiter := rate.NewLimiter(rate.Limit(bps), burst)
+	socket := newThrottledSocket(connDataSocket{serverConn}, nil, writeLimiter)
+
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			if _, err := clientConn.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	data := make([]byte, payload)
+
+	start := time.Now()
+
+	if _, err := socket.Write(data); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	elapsed := time.Since(start)
+
+	if elapsed < time.Second {
+		t.Fatalf("Write() of %d bytes at %d Bps took only %v, expected it to be rate-limited to at least 1s", payload, bps, elapsed)
+	}
+
+	if elapsed > 5*time.Second {
+		t.Fatalf("Write() took %v, expected it to finish well under 5s", elapsed)
+	}
+}
+
+func TestThrottledSocketSetWriteLimiterSwapsLiveCap(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	socket := newThrottledSocket(connDataSocket{serverConn}, nil, nil)
+
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			if _, err := clientConn.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Unthrottled write should be fast.
+	start := time.Now()
+
+	if _, err := socket.Write(make([]byte, 4096)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("unthrottled Write() took %v, expected it to be near-instant", elapsed)
+	}
+
+	// After swapping in a tight limiter, the next write should be slow.
+	socket.SetWriteLimiter(rate.NewLimiter(rate.Limit(1024), 256))
+
+	start = time.Now()
+
+	if _, err := socket.Write(make([]byte, 4096)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Fatalf("throttled Write() took only %v, expected the swapped-in limiter to slow it down", elapsed)
+	}
+}